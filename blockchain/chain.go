@@ -0,0 +1,81 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"sync"
+	"time"
+
+	"github.com/classzz/classzz/chaincfg"
+	"github.com/classzz/classzz/chaincfg/chainhash"
+	"github.com/classzz/classzz/database"
+)
+
+// MedianTimeSource provides the current time adjusted by a median offset
+// determined from known time samples.  This interface only exposes the
+// adjusted time; it has no method for adding samples.
+type MedianTimeSource interface {
+	// AdjustedTime returns the current time adjusted by the median time
+	// offset of the known samples.
+	AdjustedTime() time.Time
+}
+
+// blockIndex tracks the set of block hashes known to the chain, whether on
+// the main chain or a side chain, so blockExists can answer without a
+// database round trip.
+type blockIndex struct {
+	sync.RWMutex
+	index map[chainhash.Hash]struct{}
+}
+
+// HaveBlock returns whether hash is known to the index.
+func (bi *blockIndex) HaveBlock(hash *chainhash.Hash) bool {
+	bi.RLock()
+	defer bi.RUnlock()
+	_, exists := bi.index[*hash]
+	return exists
+}
+
+// BlockChain provides functions for working with the block chain,
+// validating and inserting new blocks, and handling reorganizations.
+type BlockChain struct {
+	chainParams *chaincfg.Params
+	db          database.DB
+	index       *blockIndex
+	timeSource  MedianTimeSource
+
+	chainLock sync.Mutex
+
+	// headerIndex tracks headers validated through ProcessBlockHeader
+	// ahead of their matching full blocks, keyed by header hash.  Together
+	// with headerHeightIndex it lets ProcessBlock apply BFFastAdd below
+	// the final checkpoint.  See belowFinalCheckpoint.
+	headerIndex map[chainhash.Hash]*headerNode
+
+	// headerHeightIndex is the height-keyed counterpart of headerIndex,
+	// letting belowFinalCheckpoint look up the hash recorded at a given
+	// height (the final checkpoint's, in particular) without scanning
+	// headerIndex.
+	headerHeightIndex map[int32]chainhash.Hash
+
+	// policy is the chain's ValidationPolicy.  New sets it to a
+	// chainParamsPolicy built from chainParams and timeSource.  When it is
+	// nil, validationPolicy returns a fresh chainParamsPolicy without
+	// storing it here.
+	policy ValidationPolicy
+
+	// scriptValidationConcurrency is the configured worker count for
+	// validateTransactionScripts, set from Config.ScriptValidationConcurrency.
+	// A value <= 0 means "use scriptValidationConcurrency's default of
+	// runtime.NumCPU()".
+	scriptValidationConcurrency int
+
+	// processingBlocks holds the hashes of blocks currently partway
+	// through ProcessBlock, under chainLock, so that two concurrent
+	// submissions of the same not-yet-known block both pay for the
+	// sanity and script validation passes only once instead of racing
+	// each other through them.
+	processingBlocks map[chainhash.Hash]struct{}
+}
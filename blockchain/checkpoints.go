@@ -0,0 +1,49 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/classzz/classzz/chaincfg"
+)
+
+// finalCheckpoint returns the final (highest) hard-coded checkpoint
+// configured for this chain, or nil if the chain has none.
+func (b *BlockChain) finalCheckpoint() *chaincfg.Checkpoint {
+	checkpoints := b.chainParams.Checkpoints
+	if len(checkpoints) == 0 {
+		return nil
+	}
+	return &checkpoints[len(checkpoints)-1]
+}
+
+// belowFinalCheckpoint returns whether the given height is at or below the
+// final hard-coded checkpoint AND the header chain has actually proven it
+// reaches that checkpoint -- the hash recorded at the checkpoint's height
+// matches chainParams, not merely its height.  A self-consistent header
+// chain that simply reaches the checkpoint height (an attacker's
+// low-difficulty fork, for instance) must not be granted the fast,
+// unvalidated path that comes with BFFastAdd.
+func (b *BlockChain) belowFinalCheckpoint(height int32) bool {
+	checkpoint := b.finalCheckpoint()
+	if checkpoint == nil || height > checkpoint.Height {
+		return false
+	}
+	return b.checkpointHashVerified(checkpoint)
+}
+
+// checkpointHashVerified reports whether the hash recorded at checkpoint's
+// height -- via the headerIndex entries ProcessBlockHeader builds up --
+// actually matches checkpoint.Hash.  It fails closed: until a header chain
+// reaching that exact hash has been processed, no height is considered
+// below the final checkpoint.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) checkpointHashVerified(checkpoint *chaincfg.Checkpoint) bool {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	hash, ok := b.headerHeightIndex[checkpoint.Height]
+	return ok && hash == *checkpoint.Hash
+}
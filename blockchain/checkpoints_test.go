@@ -0,0 +1,48 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/classzz/classzz/chaincfg"
+	"github.com/classzz/classzz/chaincfg/chainhash"
+)
+
+// TestBelowFinalCheckpointRequiresHashMatch pins belowFinalCheckpoint to
+// checking the actual hash at the final checkpoint's height, not just that
+// height being reached.  Before this fix, any self-consistent header chain
+// that merely reached the checkpoint height -- including an attacker's
+// low-difficulty fork -- was granted BFFastAdd's unvalidated fast path.
+func TestBelowFinalCheckpointRequiresHashMatch(t *testing.T) {
+	checkpointHash := chainhash.Hash{0x01}
+	wrongHash := chainhash.Hash{0x02}
+
+	b := &BlockChain{
+		chainParams: &chaincfg.Params{
+			Checkpoints: []chaincfg.Checkpoint{
+				{Height: 10, Hash: &checkpointHash},
+			},
+		},
+		headerHeightIndex: make(map[int32]chainhash.Hash),
+	}
+
+	if b.belowFinalCheckpoint(5) {
+		t.Fatal("expected belowFinalCheckpoint to fail closed with no recorded header at the checkpoint height")
+	}
+
+	b.headerHeightIndex[10] = wrongHash
+	if b.belowFinalCheckpoint(5) {
+		t.Fatal("expected belowFinalCheckpoint to reject a height match with the wrong hash")
+	}
+
+	b.headerHeightIndex[10] = checkpointHash
+	if !b.belowFinalCheckpoint(5) {
+		t.Fatal("expected belowFinalCheckpoint to succeed once the checkpoint hash is verified")
+	}
+	if b.belowFinalCheckpoint(11) {
+		t.Fatal("expected belowFinalCheckpoint to reject heights above the checkpoint")
+	}
+}
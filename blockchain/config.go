@@ -0,0 +1,54 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/classzz/classzz/chaincfg"
+	"github.com/classzz/classzz/chaincfg/chainhash"
+	"github.com/classzz/classzz/database"
+)
+
+// Config is the configuration struct used to initialize a new BlockChain.
+type Config struct {
+	// DB defines the database which houses the blocks and will be used
+	// to store all metadata created by this package.
+	DB database.DB
+
+	// ChainParams identifies which chain parameters the chain is
+	// associated with.
+	ChainParams *chaincfg.Params
+
+	// TimeSource defines the median time source to use for things such
+	// as block processing and determining whether or not the chain is
+	// current.
+	TimeSource MedianTimeSource
+
+	// ScriptValidationConcurrency, if positive, overrides the default
+	// number of goroutines (runtime.NumCPU) that validateTransactionScripts
+	// uses for parallel script validation.  It exists mainly so tests and
+	// constrained deployments can cap the worker pool instead of always
+	// consuming every core.
+	ScriptValidationConcurrency int
+}
+
+// New returns a fully initialized instance of BlockChain using the
+// provided configuration details.
+func New(config *Config) (*BlockChain, error) {
+	b := &BlockChain{
+		chainParams:                 config.ChainParams,
+		db:                          config.DB,
+		timeSource:                  config.TimeSource,
+		index:                       &blockIndex{index: make(map[chainhash.Hash]struct{})},
+		headerIndex:                 make(map[chainhash.Hash]*headerNode),
+		headerHeightIndex:           make(map[int32]chainhash.Hash),
+		scriptValidationConcurrency: config.ScriptValidationConcurrency,
+		processingBlocks:            make(map[chainhash.Hash]struct{}),
+	}
+	b.policy = &chainParamsPolicy{
+		params:     config.ChainParams,
+		timeSource: config.TimeSource,
+	}
+	return b, nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/classzz/classzz/chaincfg/chainhash"
+	"github.com/classzz/classzz/wire"
+)
+
+// headerNode tracks a header that has been validated during the
+// headers-first phase of initial block download.  Its associated full
+// block is not necessarily downloaded or connected yet.
+type headerNode struct {
+	height int32
+	header *wire.BlockHeader
+}
+
+// ProcessBlockHeader is the headers-first counterpart to ProcessBlock.  It
+// performs only the checks that can be evaluated from a bare header --
+// proof of work, timestamp, bits and prevHash linkage -- and records the
+// result in headerIndex.  This lets the sync manager walk a long header
+// chain up to a known checkpoint before requesting a single full block,
+// which is the basis of the headers-first IBD speedup.
+//
+// A header's parent is resolved against headerIndex first, the common
+// case while walking a chain of newly announced headers, falling back to
+// the already-connected chain (resolvePrevHeaderHeight).  The fallback is
+// what lets headers-first sync resume: headerIndex itself isn't
+// persisted, so after a restart -- or whenever a getheaders response
+// doesn't start from genesis, which is normal protocol behavior -- the
+// parent of the first header in a batch is only known to the connected
+// chain, not to this in-memory index.
+//
+// It does not touch the database, the block index or any consensus state;
+// those are only updated once the matching full block is accepted through
+// ProcessBlock.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessBlockHeader(header *wire.BlockHeader, flags BehaviorFlags) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	headerHash := header.BlockHash()
+	if _, exists := b.headerIndex[headerHash]; exists {
+		return nil
+	}
+
+	prevHeight, prevKnown, err := b.resolvePrevHeaderHeight(&header.PrevBlock)
+	if err != nil {
+		return err
+	}
+	if !prevKnown {
+		str := fmt.Sprintf("header %v does not extend a known header chain", headerHash)
+		return ruleError(ErrMissingParent, str)
+	}
+
+	if err := checkHeaderSanity(header, b.chainParams.PowLimit, b.timeSource, flags); err != nil {
+		return err
+	}
+
+	height := prevHeight + 1
+	b.headerIndex[headerHash] = &headerNode{
+		height: height,
+		header: header,
+	}
+	b.headerHeightIndex[height] = headerHash
+
+	return nil
+}
+
+// resolvePrevHeaderHeight resolves the height of the block identified by
+// prevHash -- the parent a header being processed extends -- so its own
+// height can be recorded as prevHeight+1.
+//
+// It checks headerIndex and genesis first, then falls back to the
+// already-connected chain via blockExists/BlockHeightByHashAll.  The
+// fallback covers the case where prevHash was connected through
+// ProcessBlock directly, or through a prior run of ProcessBlockHeader
+// whose in-memory headerIndex entry didn't survive a restart.
+//
+// This function MUST be called with the chain state lock held.
+func (b *BlockChain) resolvePrevHeaderHeight(prevHash *chainhash.Hash) (int32, bool, error) {
+	if prevNode, exists := b.headerIndex[*prevHash]; exists {
+		return prevNode.height, true, nil
+	}
+	if *prevHash == *b.chainParams.GenesisHash {
+		return 0, true, nil
+	}
+
+	exists, err := b.blockExists(prevHash)
+	if err != nil {
+		return 0, false, err
+	}
+	if !exists {
+		return 0, false, nil
+	}
+
+	height, err := b.BlockHeightByHashAll(prevHash)
+	if err != nil {
+		return 0, false, err
+	}
+	return height, true, nil
+}
+
+// checkHeaderSanity performs the subset of checkBlockSanity that only
+// requires a bare block header: proof of work and the timestamp.  It does
+// not check anything that requires the block's transactions, such as the
+// merkle root, since those aren't available yet in headers-first mode.
+func checkHeaderSanity(header *wire.BlockHeader, powLimit *big.Int, timeSource MedianTimeSource, flags BehaviorFlags) error {
+	if !flags.HasFlag(BFNoPoWCheck) {
+		if err := checkProofOfWork(header, powLimit, flags); err != nil {
+			return err
+		}
+	}
+
+	if header.Timestamp.After(timeSource.AdjustedTime().Add(time.Second * maxTimeOffsetSeconds)) {
+		str := fmt.Sprintf("header timestamp %v is too far in the future", header.Timestamp)
+		return ruleError(ErrTimeTooNew, str)
+	}
+
+	return nil
+}
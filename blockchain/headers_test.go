@@ -0,0 +1,123 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/classzz/classzz/chaincfg"
+	"github.com/classzz/classzz/chaincfg/chainhash"
+	"github.com/classzz/classzz/wire"
+)
+
+type fakeTimeSource time.Time
+
+func (f fakeTimeSource) AdjustedTime() time.Time { return time.Time(f) }
+
+func TestCheckHeaderSanityRejectsFutureTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := &wire.BlockHeader{Timestamp: now.Add(time.Second*maxTimeOffsetSeconds + time.Second)}
+
+	if err := checkHeaderSanity(header, big.NewInt(0), fakeTimeSource(now), BFNoPoWCheck); err == nil {
+		t.Fatal("expected an error for a header timestamped too far in the future")
+	}
+}
+
+func TestCheckHeaderSanityAcceptsCurrentTimestamp(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	header := &wire.BlockHeader{Timestamp: now}
+
+	if err := checkHeaderSanity(header, big.NewInt(0), fakeTimeSource(now), BFNoPoWCheck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func newTestChain() *BlockChain {
+	genesisHash := chainhash.Hash{0xff}
+	return &BlockChain{
+		chainParams: &chaincfg.Params{
+			GenesisHash: &genesisHash,
+			PowLimit:    big.NewInt(0),
+		},
+		timeSource:        fakeTimeSource(time.Unix(1700000000, 0)),
+		headerIndex:       make(map[chainhash.Hash]*headerNode),
+		headerHeightIndex: make(map[int32]chainhash.Hash),
+	}
+}
+
+// TestProcessBlockHeaderGenesisPrev pins the common headers-first path: a
+// header extending genesis is accepted and recorded at height 1.
+func TestProcessBlockHeaderGenesisPrev(t *testing.T) {
+	b := newTestChain()
+
+	header := &wire.BlockHeader{
+		PrevBlock: *b.chainParams.GenesisHash,
+		Timestamp: time.Unix(1700000000, 0),
+	}
+
+	if err := b.ProcessBlockHeader(header, BFNoPoWCheck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	node, exists := b.headerIndex[header.BlockHash()]
+	if !exists {
+		t.Fatal("expected header to be recorded in headerIndex")
+	}
+	if node.height != 1 {
+		t.Fatalf("expected height 1, got %d", node.height)
+	}
+	if b.headerHeightIndex[1] != header.BlockHash() {
+		t.Fatal("expected headerHeightIndex to record the header's hash at height 1")
+	}
+}
+
+// TestProcessBlockHeaderKnownPrev pins that a header extending another
+// header already recorded in headerIndex builds on that header's height.
+func TestProcessBlockHeaderKnownPrev(t *testing.T) {
+	b := newTestChain()
+
+	first := &wire.BlockHeader{
+		PrevBlock: *b.chainParams.GenesisHash,
+		Timestamp: time.Unix(1700000000, 0),
+	}
+	if err := b.ProcessBlockHeader(first, BFNoPoWCheck); err != nil {
+		t.Fatalf("unexpected error processing first header: %v", err)
+	}
+
+	second := &wire.BlockHeader{
+		PrevBlock: first.BlockHash(),
+		Timestamp: time.Unix(1700000100, 0),
+	}
+	if err := b.ProcessBlockHeader(second, BFNoPoWCheck); err != nil {
+		t.Fatalf("unexpected error processing second header: %v", err)
+	}
+
+	node, exists := b.headerIndex[second.BlockHash()]
+	if !exists {
+		t.Fatal("expected second header to be recorded in headerIndex")
+	}
+	if node.height != 2 {
+		t.Fatalf("expected height 2, got %d", node.height)
+	}
+}
+
+// TestProcessBlockHeaderDuplicate pins that resubmitting an already-known
+// header is a no-op rather than an error.
+func TestProcessBlockHeaderDuplicate(t *testing.T) {
+	b := newTestChain()
+
+	header := &wire.BlockHeader{
+		PrevBlock: *b.chainParams.GenesisHash,
+		Timestamp: time.Unix(1700000000, 0),
+	}
+	if err := b.ProcessBlockHeader(header, BFNoPoWCheck); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.ProcessBlockHeader(header, BFNoPoWCheck); err != nil {
+		t.Fatalf("unexpected error resubmitting a known header: %v", err)
+	}
+}
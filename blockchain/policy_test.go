@@ -0,0 +1,77 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/classzz/classzz/chaincfg"
+)
+
+// TestBeaconCheckRequired pins beaconCheckRequired's behavior at the
+// boundary heights around BeaconHeight and ConverHeight, in particular at
+// height == BeaconHeight where CheckBeacon must NOT run -- the original
+// gate was strict on both ends ("BeaconHeight < height && height <
+// ConverHeight"), and it's easy to regress that to a non-strict lower
+// bound by reusing the IsRuleActive(RuleBeacon, ...) formula instead.
+func TestBeaconCheckRequired(t *testing.T) {
+	params := &chaincfg.Params{
+		BeaconHeight: 100,
+		ConverHeight: 200,
+	}
+
+	tests := []struct {
+		name   string
+		height int32
+		want   bool
+	}{
+		{"below BeaconHeight", 99, false},
+		{"at BeaconHeight", 100, false},
+		{"just above BeaconHeight", 101, true},
+		{"just below ConverHeight", 199, true},
+		{"at ConverHeight", 200, false},
+		{"above ConverHeight", 201, false},
+	}
+
+	for _, test := range tests {
+		got := beaconCheckRequired(params, test.height)
+		if got != test.want {
+			t.Errorf("%s: beaconCheckRequired(%d) = %v, want %v",
+				test.name, test.height, got, test.want)
+		}
+	}
+}
+
+// TestIsRuleActiveRuleBeacon checks that chainParamsPolicy.IsRuleActive
+// still uses its own, non-strict-lower-bound formula for RuleBeacon -- it
+// answers a different question from beaconCheckRequired (whether the
+// beacon rule set is active at all, as used by the prevHeight-based
+// EntangleState selection in ProcessBlock) and must not be made to agree
+// with it.
+func TestIsRuleActiveRuleBeacon(t *testing.T) {
+	params := &chaincfg.Params{
+		BeaconHeight: 100,
+		ConverHeight: 200,
+	}
+	policy := &chainParamsPolicy{params: params}
+
+	tests := []struct {
+		height int32
+		want   bool
+	}{
+		{99, false},
+		{100, true},
+		{199, true},
+		{200, false},
+	}
+
+	for _, test := range tests {
+		got := policy.IsRuleActive(RuleBeacon, test.height)
+		if got != test.want {
+			t.Errorf("IsRuleActive(RuleBeacon, %d) = %v, want %v",
+				test.height, got, test.want)
+		}
+	}
+}
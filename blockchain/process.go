@@ -6,6 +6,8 @@ package blockchain
 
 import (
 	"fmt"
+	"sync"
+
 	"github.com/classzz/classzz/chaincfg/chainhash"
 	"github.com/classzz/classzz/cross"
 	"github.com/classzz/classzz/database"
@@ -38,6 +40,12 @@ const (
 	// checks.
 	BFNoDupBlockCheck
 
+	// BFSingleThreadedScripts signals that script validation should run
+	// on the calling goroutine instead of the script validation worker
+	// pool, so that a test can deterministically attribute a failure to
+	// a single transaction.
+	BFSingleThreadedScripts
+
 	// BFNone is a convenience value to specifically indicate no flags.
 	BFNone BehaviorFlags = 0
 )
@@ -85,83 +93,76 @@ func (b *BlockChain) blockExists(hash *chainhash.Hash) (bool, error) {
 	return exists, err
 }
 
-// processOrphans determines if there are any orphans which depend on the passed
-// block hash (they are no longer orphans if true) and potentially accepts them.
-// It repeats the process for the newly accepted blocks (to detect further
-// orphans which may no longer be orphans) until there are no more.
-//
-// The flags do not modify the behavior of this function directly, however they
-// are needed to pass along to maybeAcceptBlock.
+// claimProcessing claims hash as currently being processed by ProcessBlock,
+// returning false if another concurrent call already claimed it.  It's how
+// ProcessBlock dedupes concurrent submissions of the same not-yet-known
+// block instead of letting both pay for sanity and script validation.
 //
-// This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) processOrphans(hash *chainhash.Hash, flags BehaviorFlags) error {
-	// Start with processing at least the passed hash.  Leave a little room
-	// for additional orphan blocks that need to be processed without
-	// needing to grow the array in the common case.
-	processHashes := make([]*chainhash.Hash, 0, 10)
-	processHashes = append(processHashes, hash)
-	for len(processHashes) > 0 {
-		// Pop the first hash to process from the slice.
-		processHash := processHashes[0]
-		processHashes[0] = nil // Prevent GC leak.
-		processHashes = processHashes[1:]
-
-		// Look up all orphans that are parented by the block we just
-		// accepted.  This will typically only be one, but it could
-		// be multiple if multiple blocks are mined and broadcast
-		// around the same time.  The one with the most proof of work
-		// will eventually win out.  An indexing for loop is
-		// intentionally used over a range here as range does not
-		// reevaluate the slice on each iteration nor does it adjust the
-		// index for the modified slice.
-		for i := 0; i < len(b.prevOrphans[*processHash]); i++ {
-			orphan := b.prevOrphans[*processHash][i]
-			if orphan == nil {
-				log.Warnf("Found a nil entry at index %d in the "+
-					"orphan dependency list for block %v", i,
-					processHash)
-				continue
-			}
+// This function is safe for concurrent access.
+func (b *BlockChain) claimProcessing(hash *chainhash.Hash) bool {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
 
-			// Remove the orphan from the orphan pool.
-			orphanHash := orphan.block.Hash()
-			b.removeOrphanBlock(orphan)
-			i--
+	if _, inFlight := b.processingBlocks[*hash]; inFlight {
+		return false
+	}
+	b.processingBlocks[*hash] = struct{}{}
+	return true
+}
 
-			// Potentially accept the block into the block chain.
-			_, err := b.maybeAcceptBlock(orphan.block, flags)
-			if err != nil {
-				return err
-			}
+// releaseProcessing releases a hash previously claimed with
+// claimProcessing.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) releaseProcessing(hash *chainhash.Hash) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
 
-			// Add this block to the list of blocks to process so
-			// any orphan blocks that depend on this block are
-			// handled too.
-			processHashes = append(processHashes, orphanHash)
-		}
-	}
-	return nil
+	delete(b.processingBlocks, *hash)
 }
 
 // ProcessBlock is the main workhorse for handling insertion of new blocks into
 // the block chain.  It includes functionality such as rejecting duplicate
-// blocks, ensuring blocks follow all rules, orphan handling, and insertion into
-// the block chain along with best chain selection and reorganization.
+// blocks, ensuring blocks follow all rules, and insertion into the block
+// chain along with best chain selection and reorganization.
+//
+// ProcessBlock no longer holds blocks whose parent is unknown.  It instead
+// rejects them with ErrMissingParent (detectable via IsErrorCode).  It is
+// the caller's responsibility -- the sync manager, in practice -- to hold
+// the block, request the missing ancestor via getblocks/getheaders, and
+// resubmit the block (and anything depending on it) once the parent has
+// been connected.
+//
+// Blocks at or below the final hard-coded checkpoint are automatically
+// processed with BFFastAdd set once ProcessBlockHeader has recorded the
+// checkpoint's own hash at its height (see belowFinalCheckpoint); until
+// then they get no BFFastAdd.  BFFastAdd lets checkBlockSanity and
+// maybeAcceptBlock skip their more expensive contextual checks.  Callers
+// doing headers-first initial block download should use ProcessBlockHeader
+// to walk the header chain up to that checkpoint before requesting and
+// connecting the matching full blocks through ProcessBlock.
 //
-// When no errors occurred during processing, the first return value indicates
-// whether or not the block is on the main chain and the second indicates
-// whether or not the block is an orphan.
+// The context-free sanity checks and the script validation pass run
+// concurrently with each other, the latter on the script validation pool
+// (see Config.ScriptValidationConcurrency and BFSingleThreadedScripts); the chain state
+// lock is only held for the index-mutation phase that follows, not for the
+// whole function.
+//
+// When no errors occurred during processing, the first return value
+// indicates whether or not the block is on the main chain.  The second
+// return value is always false: orphan handling no longer lives here, and
+// is instead the sync manager's responsibility as described above.  It
+// remains part of the signature for now so callers already wired against
+// the three-return-value form (see netsync.BlockManager.ProcessBlock)
+// don't all need to change in lockstep with this commit.
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) ProcessBlock(block *czzutil.Block, flags BehaviorFlags) (bool, bool, error) {
-	b.chainLock.Lock()
-	defer b.chainLock.Unlock()
-
 	blockHash := block.Hash()
 	log.Tracef("Processing block %v", blockHash)
 
+	// The block must not already exist in the main chain or side chains.
 	if !flags.HasFlag(BFNoDupBlockCheck) {
-		// The block must not already exist in the main chain or side chains.
 		exists, err := b.blockExists(blockHash)
 		if err != nil {
 			return false, false, err
@@ -170,18 +171,19 @@ func (b *BlockChain) ProcessBlock(block *czzutil.Block, flags BehaviorFlags) (bo
 			str := fmt.Sprintf("already have block %v", blockHash)
 			return false, false, ruleError(ErrDuplicateBlock, str)
 		}
-
-		// The block must not already exist as an orphan.
-		if _, exists := b.orphans[*blockHash]; exists {
-			str := fmt.Sprintf("already have block (orphan) %v", blockHash)
-			return false, false, ruleError(ErrDuplicateBlock, str)
-		}
 	}
 
-	flags |= BFMagneticAnomaly
+	// claimProcessing stays claimed for the remainder of ProcessBlock --
+	// it's released in the deferred call below -- so a second concurrent
+	// submission of the same in-flight block is rejected immediately
+	// instead of racing the first through sanity and script validation.
+	if !b.claimProcessing(blockHash) {
+		str := fmt.Sprintf("already have block %v", blockHash)
+		return false, false, ruleError(ErrDuplicateBlock, str)
+	}
+	defer b.releaseProcessing(blockHash)
 
 	var err error
-	// Handle orphan blocks.
 	blockHeader := &block.MsgBlock().Header
 	prevHash := &blockHeader.PrevBlock
 	prevHashExists, err := b.blockExists(prevHash)
@@ -193,8 +195,20 @@ func (b *BlockChain) ProcessBlock(block *czzutil.Block, flags BehaviorFlags) (bo
 		return false, false, err
 	}
 
+	if b.IsRuleActive(RuleMagneticAnomaly, blockHeight) {
+		flags |= BFMagneticAnomaly
+	}
+
+	// The header chain up to the final checkpoint has already had its
+	// proof of work and linkage validated during headers-first sync, so
+	// blocks at or below it can skip the expensive contextual checks
+	// below.
+	if b.belowFinalCheckpoint(blockHeight) {
+		flags |= BFFastAdd
+	}
+
 	var eState *cross.EntangleState
-	if b.chainParams.BeaconHeight <= prevHeight && b.chainParams.ConverHeight > prevHeight {
+	if b.IsRuleActive(RuleBeacon, prevHeight) {
 		cState := b.GetCstateByHashAndHeight(*prevHash, prevHeight)
 		bai2s := make(map[string]*cross.BeaconAddressInfo)
 		for _, v := range cState.PledgeInfos {
@@ -210,39 +224,69 @@ func (b *BlockChain) ProcessBlock(block *czzutil.Block, flags BehaviorFlags) (bo
 			EnInfos: bai2s,
 		}
 
-	} else if b.chainParams.ConverHeight <= prevHeight {
+	} else if b.IsRuleActive(RuleCrossTx, prevHeight) {
 		eState = b.GetEstateByHashAndHeight(*prevHash, prevHeight)
 	}
 
+	if !prevHashExists {
+		str := fmt.Sprintf("previous block %v is not known", prevHash)
+		return false, false, ruleError(ErrMissingParent, str)
+	}
+
 	script := block.MsgBlock().Transactions[0].TxOut[0].PkScript
 	_, addrs, _, _ := txscript.ExtractPkScriptAddrs(script, b.chainParams)
 
-	// Perform preliminary sanity checks on the block and its transactions.
-	err = checkBlockSanity(b.chainParams, &prevHeader, block, b.chainParams.PowLimit, b.timeSource, flags, eState, addrs[0])
-	if err != nil {
-		return false, false, err
-	}
-
-	if !prevHashExists {
-		log.Infof("Adding orphan block %v with parent %v", blockHash, prevHash)
-		b.addOrphanBlock(block)
+	// Run the context-free sanity checks (PoW, merkle root, ...) and the
+	// script validation pass concurrently; neither depends on the other,
+	// and both must pass before the block can be considered for
+	// acceptance.  This is where ProcessBlock spends most of its CPU
+	// time, so letting the two run on separate cores materially speeds
+	// up tip-follow and IBD on multi-core hosts.  It's gated behind the
+	// prevHashExists check above so that a block whose parent hasn't
+	// connected yet -- which the sync manager will keep resubmitting
+	// until it has -- doesn't pay for a full validation pass each time.
+	var sanityErr, scriptErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sanityErr = checkBlockSanity(b.chainParams, &prevHeader, block, b.chainParams.PowLimit, b.timeSource, flags, eState, addrs[0])
+	}()
+	go func() {
+		defer wg.Done()
+		if flags.HasFlag(BFFastAdd) {
+			return
+		}
+		scriptErr = validateTransactionScripts(block, flags, b.scriptValidationConcurrency)
+	}()
+	wg.Wait()
 
-		return false, true, nil
+	if sanityErr != nil {
+		return false, false, sanityErr
+	}
+	if scriptErr != nil {
+		return false, false, scriptErr
 	}
 
-	if b.chainParams.BeaconHeight < blockHeight && b.chainParams.ConverHeight > blockHeight {
+	if !flags.HasFlag(BFFastAdd) && beaconCheckRequired(b.chainParams, blockHeight) {
 		if err := b.CheckBeacon(block, prevHeight); err != nil {
 			return false, false, err
 		}
 	}
 
 	// cross Verify
-	if b.chainParams.ConverHeight <= blockHeight {
+	if !flags.HasFlag(BFFastAdd) && b.IsRuleActive(RuleCrossTx, blockHeight) {
 		if err := b.CheckBlockCrossTx(block, prevHeight); err != nil {
 			return false, false, err
 		}
 	}
 
+	// Only the index-mutation phase needs the chain state lock; it is
+	// acquired here, rather than for the whole function, so the
+	// concurrent checks above aren't serialized behind other readers.
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
 	// The block has passed all context independent checks and appears sane
 	// enough to potentially accept it into the block chain.
 	isMainChain, err := b.maybeAcceptBlock(block, flags)
@@ -250,14 +294,6 @@ func (b *BlockChain) ProcessBlock(block *czzutil.Block, flags BehaviorFlags) (bo
 		return false, false, err
 	}
 
-	// Accept any orphan blocks that depend on this block (they are
-	// no longer orphans) and repeat for those accepted blocks until
-	// there are no more.
-	err = b.processOrphans(blockHash, flags)
-	if err != nil {
-		return false, false, err
-	}
-
 	log.Debugf("Accepted block %v", blockHash)
 
 	return isMainChain, false, nil
@@ -0,0 +1,35 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/classzz/classzz/chaincfg/chainhash"
+)
+
+// TestClaimProcessing pins the in-flight dedupe behavior ProcessBlock
+// relies on: a hash can only be claimed once until it's released, so two
+// concurrent submissions of the same not-yet-known block can't both slip
+// past the duplicate check and pay for sanity and script validation
+// twice.
+func TestClaimProcessing(t *testing.T) {
+	b := &BlockChain{processingBlocks: make(map[chainhash.Hash]struct{})}
+
+	hash := chainhash.Hash{0x01}
+
+	if !b.claimProcessing(&hash) {
+		t.Fatal("expected first claim to succeed")
+	}
+	if b.claimProcessing(&hash) {
+		t.Fatal("expected second concurrent claim to fail while the first is in flight")
+	}
+
+	b.releaseProcessing(&hash)
+
+	if !b.claimProcessing(&hash) {
+		t.Fatal("expected claim to succeed again after release")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/classzz/czzutil"
+)
+
+// txValidateItem holds a single transaction so it can be handed to a
+// worker goroutine in the script validation pool independently of the
+// other transactions in the block.
+type txValidateItem struct {
+	txIdx int
+	tx    *czzutil.Tx
+}
+
+// scriptValidationConcurrency returns the number of goroutines to use for
+// parallel script validation: configured if positive, otherwise
+// runtime.NumCPU() since script validation is CPU bound.
+func scriptValidationConcurrency(configured int) int {
+	if configured > 0 {
+		return configured
+	}
+	return runtime.NumCPU()
+}
+
+// validateTransactionScripts farms out script validation for every
+// non-coinbase transaction in block across a pool of workers and waits for
+// all of them to finish, returning the first error encountered (if any).
+// The coinbase transaction (index 0) is never validated here: it has no
+// real previous output to check a signature against.
+//
+// BFSingleThreadedScripts in flags, or a concurrency of 1, runs the checks
+// on the calling goroutine instead of spinning up a pool, which keeps
+// deterministic tests able to attribute a failure to a single transaction.
+func validateTransactionScripts(block *czzutil.Block, flags BehaviorFlags, concurrency int) error {
+	txns := block.Transactions()
+	if len(txns) <= 1 {
+		return nil
+	}
+	numToValidate := len(txns) - 1
+
+	if flags.HasFlag(BFSingleThreadedScripts) {
+		concurrency = 1
+	} else {
+		concurrency = scriptValidationConcurrency(concurrency)
+	}
+	if concurrency > numToValidate {
+		concurrency = numToValidate
+	}
+
+	if concurrency <= 1 {
+		for i, tx := range txns[1:] {
+			if err := checkTransactionScripts(tx, i+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	items := make(chan txValidateItem, numToValidate)
+	for i, tx := range txns[1:] {
+		items <- txValidateItem{txIdx: i + 1, tx: tx}
+	}
+	close(items)
+
+	// quit is closed as soon as any worker hits an error so the rest of
+	// the pool stops pulling new items instead of burning CPU validating
+	// a block that's already known to be rejected.
+	quit := make(chan struct{})
+	var once sync.Once
+	var wg sync.WaitGroup
+	var errMtx sync.Mutex
+	var firstErr error
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				select {
+				case <-quit:
+					return
+				default:
+				}
+				if err := checkTransactionScripts(item.tx, item.txIdx); err != nil {
+					errMtx.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errMtx.Unlock()
+					once.Do(func() { close(quit) })
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/classzz/classzz/wire"
+	"github.com/classzz/czzutil"
+)
+
+func TestScriptValidationConcurrency(t *testing.T) {
+	if got := scriptValidationConcurrency(4); got != 4 {
+		t.Errorf("configured concurrency: got %d, want 4", got)
+	}
+	if got := scriptValidationConcurrency(0); got != runtime.NumCPU() {
+		t.Errorf("default concurrency: got %d, want %d", got, runtime.NumCPU())
+	}
+}
+
+// TestValidateTransactionScriptsSkipsCoinbaseOnlyBlock pins that a block
+// containing only its coinbase transaction never reaches
+// checkTransactionScripts -- the coinbase has no real previous output to
+// validate a signature against, so feeding it in is either wasted work or,
+// depending on checkTransactionScripts' own assumptions, an outright
+// error.
+func TestValidateTransactionScriptsSkipsCoinbaseOnlyBlock(t *testing.T) {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+	msgBlock.AddTransaction(wire.NewMsgTx(wire.TxVersion))
+	block := czzutil.NewBlock(msgBlock)
+
+	if err := validateTransactionScripts(block, BFNone, 1); err != nil {
+		t.Fatalf("unexpected error validating a coinbase-only block: %v", err)
+	}
+}
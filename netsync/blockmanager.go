@@ -0,0 +1,308 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package netsync implements a concurrency safe block handling manager for
+// the peer-to-peer layer.
+//
+// It is also home to the orphan block pool that used to live inside
+// blockchain.BlockChain.  blockchain.BlockChain.ProcessBlock is a pure
+// consensus-rule function now: it rejects a block whose parent is unknown
+// with blockchain.ErrMissingParent instead of holding it.  BlockManager is
+// what actually holds those blocks, requests the missing ancestor, and
+// resubmits them (and anything depending on them) once that ancestor
+// connects.
+package netsync
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/classzz/classzz/blockchain"
+	"github.com/classzz/classzz/chaincfg/chainhash"
+	"github.com/classzz/classzz/wire"
+	"github.com/classzz/czzutil"
+)
+
+const (
+	// maxOrphanBlocks is the maximum number of orphan blocks the manager
+	// will hold onto at once.  It bounds the memory the orphan pool can
+	// consume independently of anything in the consensus code.
+	maxOrphanBlocks = 100
+
+	// orphanExpiration is how long an orphan block is kept before it's
+	// evicted if its parent never shows up.
+	orphanExpiration = time.Hour
+)
+
+// orphanBlock is a block paired with the time at which it should be
+// evicted from the pool if its parent still hasn't connected.
+type orphanBlock struct {
+	block      *czzutil.Block
+	expiration time.Time
+}
+
+// AncestorRequester is the minimal surface the block manager needs from the
+// p2p layer: once a block comes back from ProcessBlock with
+// blockchain.ErrMissingParent, the manager asks for the missing ancestor
+// (typically via getblocks/getheaders) through this interface.
+type AncestorRequester interface {
+	RequestAncestor(root *chainhash.Hash)
+}
+
+// ConnectCallback is invoked every time a block is connected to the best
+// chain, whether directly or as a previously orphaned block being
+// resubmitted.  It replaces the connect notifications mempool and indexers
+// used to get directly from blockchain.BlockChain.  Only the submitted
+// block is reported: other blocks that join the best chain through a
+// reorganization it triggers are not.
+type ConnectCallback func(block *czzutil.Block)
+
+// BlockManager owns the orphan pool and is the entry point blocks coming
+// from peers should be submitted through instead of
+// blockchain.BlockChain.ProcessBlock directly.
+type BlockManager struct {
+	chain     *blockchain.BlockChain
+	requester AncestorRequester
+
+	mtx          sync.Mutex
+	orphans      map[chainhash.Hash]*orphanBlock
+	prevOrphans  map[chainhash.Hash][]*orphanBlock
+	oldestOrphan *orphanBlock
+
+	callbacksMtx sync.Mutex
+	callbacks    []ConnectCallback
+}
+
+// New returns a BlockManager that submits blocks to chain and requests
+// missing ancestors through requester.
+func New(chain *blockchain.BlockChain, requester AncestorRequester) *BlockManager {
+	return &BlockManager{
+		chain:       chain,
+		requester:   requester,
+		orphans:     make(map[chainhash.Hash]*orphanBlock),
+		prevOrphans: make(map[chainhash.Hash][]*orphanBlock),
+	}
+}
+
+// Subscribe registers cb to be called every time a block is connected to
+// the best chain.
+func (bm *BlockManager) Subscribe(cb ConnectCallback) {
+	bm.callbacksMtx.Lock()
+	defer bm.callbacksMtx.Unlock()
+	bm.callbacks = append(bm.callbacks, cb)
+}
+
+// notify calls every registered callback with block.
+func (bm *BlockManager) notify(block *czzutil.Block) {
+	bm.callbacksMtx.Lock()
+	callbacks := append([]ConnectCallback(nil), bm.callbacks...)
+	bm.callbacksMtx.Unlock()
+
+	for _, cb := range callbacks {
+		cb(block)
+	}
+}
+
+// IsKnownOrphan returns whether hash is the hash of a block that is
+// already known to be an orphan, so the caller doesn't request it again as
+// a parent.
+//
+// This function is safe for concurrent access.
+func (bm *BlockManager) IsKnownOrphan(hash *chainhash.Hash) bool {
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	_, exists := bm.orphans[*hash]
+	return exists
+}
+
+// OrphanRoot returns the head of the orphan chain for the provided hash.
+//
+// This function is safe for concurrent access.
+func (bm *BlockManager) OrphanRoot(hash *chainhash.Hash) *chainhash.Hash {
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	return bm.orphanRoot(hash)
+}
+
+// orphanRoot is the lock-held counterpart of OrphanRoot.
+//
+// This function MUST be called with the manager lock held.
+func (bm *BlockManager) orphanRoot(hash *chainhash.Hash) *chainhash.Hash {
+	orphanRoot := hash
+	prevHash := hash
+	for {
+		orphan, exists := bm.orphans[*prevHash]
+		if !exists {
+			break
+		}
+		orphanRoot = prevHash
+		prevHash = &orphan.block.MsgBlock().Header.PrevBlock
+	}
+	return orphanRoot
+}
+
+// removeOrphanBlock removes orphan from the orphan pool and the previous
+// orphan index.
+//
+// This function MUST be called with the manager lock held.
+func (bm *BlockManager) removeOrphanBlock(orphan *orphanBlock) {
+	orphanHash := orphan.block.Hash()
+	delete(bm.orphans, *orphanHash)
+
+	// If the orphan being removed was the tracked oldest one, it has to
+	// be recomputed here from the survivors rather than simply reset to
+	// nil.  Leaving it nil would let the next addOrphanBlock call crown
+	// whatever gets inserted next as "oldest" regardless of how many
+	// genuinely older orphans remain in the pool, which would make the
+	// maxOrphanBlocks cap evict the wrong entry.
+	if bm.oldestOrphan == orphan {
+		bm.oldestOrphan = nil
+		for _, oBlock := range bm.orphans {
+			if bm.oldestOrphan == nil || oBlock.expiration.Before(bm.oldestOrphan.expiration) {
+				bm.oldestOrphan = oBlock
+			}
+		}
+	}
+
+	prevHash := &orphan.block.MsgBlock().Header.PrevBlock
+	orphans := bm.prevOrphans[*prevHash]
+	for i := 0; i < len(orphans); i++ {
+		if orphans[i].block.Hash().IsEqual(orphanHash) {
+			orphans = append(orphans[:i], orphans[i+1:]...)
+			i--
+		}
+	}
+	if len(orphans) == 0 {
+		delete(bm.prevOrphans, *prevHash)
+		return
+	}
+	bm.prevOrphans[*prevHash] = orphans
+}
+
+// addOrphanBlock adds block to the orphan pool, evicting expired orphans
+// and, if the pool is already at maxOrphanBlocks, the oldest orphan, then
+// requests the missing ancestor through the manager's AncestorRequester.
+//
+// This function MUST be called with the manager lock held.
+func (bm *BlockManager) addOrphanBlock(block *czzutil.Block) {
+	for _, oBlock := range bm.orphans {
+		if time.Now().After(oBlock.expiration) {
+			bm.removeOrphanBlock(oBlock)
+		}
+	}
+
+	if len(bm.orphans) >= maxOrphanBlocks && bm.oldestOrphan != nil {
+		bm.removeOrphanBlock(bm.oldestOrphan)
+	}
+
+	blockHash := block.Hash()
+	oBlock := &orphanBlock{
+		block:      block,
+		expiration: time.Now().Add(orphanExpiration),
+	}
+	bm.orphans[*blockHash] = oBlock
+
+	prevHash := &block.MsgBlock().Header.PrevBlock
+	bm.prevOrphans[*prevHash] = append(bm.prevOrphans[*prevHash], oBlock)
+
+	if bm.oldestOrphan == nil || oBlock.expiration.Before(bm.oldestOrphan.expiration) {
+		bm.oldestOrphan = oBlock
+	}
+
+	if bm.requester != nil {
+		bm.requester.RequestAncestor(bm.orphanRoot(blockHash))
+	}
+}
+
+// ProcessBlock is the sync manager's counterpart to
+// blockchain.BlockChain.ProcessBlock.  It forwards directly to the chain;
+// if the chain rejects the block with blockchain.ErrMissingParent, the
+// block is cached as an orphan and its missing ancestor is requested
+// instead of the block being dropped.
+func (bm *BlockManager) ProcessBlock(block *czzutil.Block, flags blockchain.BehaviorFlags) (bool, error) {
+	blockHash := block.Hash()
+
+	bm.mtx.Lock()
+	if bm.isKnownOrphan(blockHash) {
+		bm.mtx.Unlock()
+		return false, fmt.Errorf("already have block (orphan) %v", blockHash)
+	}
+	bm.mtx.Unlock()
+
+	isMainChain, _, err := bm.chain.ProcessBlock(block, flags)
+	if err == nil {
+		if isMainChain {
+			bm.notify(block)
+		}
+
+		bm.mtx.Lock()
+		bm.processOrphans(blockHash, flags)
+		bm.mtx.Unlock()
+
+		return isMainChain, nil
+	}
+
+	if !blockchain.IsErrorCode(err, blockchain.ErrMissingParent) {
+		return false, err
+	}
+
+	bm.mtx.Lock()
+	bm.addOrphanBlock(block)
+	bm.mtx.Unlock()
+
+	return false, nil
+}
+
+// ProcessBlockHeader forwards header to the chain's headers-first header
+// validation.  This is what drives ProcessBlockHeader during initial block
+// download: the sync manager walks the announced header chain through here
+// up to the final checkpoint before requesting and connecting the matching
+// full blocks through ProcessBlock.
+func (bm *BlockManager) ProcessBlockHeader(header *wire.BlockHeader, flags blockchain.BehaviorFlags) error {
+	return bm.chain.ProcessBlockHeader(header, flags)
+}
+
+// isKnownOrphan is the lock-held counterpart of IsKnownOrphan.
+//
+// This function MUST be called with the manager lock held.
+func (bm *BlockManager) isKnownOrphan(hash *chainhash.Hash) bool {
+	_, exists := bm.orphans[*hash]
+	return exists
+}
+
+// processOrphans accepts any orphans that were waiting on hash to connect
+// and repeats the process for the newly accepted blocks until there are no
+// more.
+//
+// This function MUST be called with the manager lock held.
+func (bm *BlockManager) processOrphans(hash *chainhash.Hash, flags blockchain.BehaviorFlags) {
+	processHashes := []*chainhash.Hash{hash}
+	for len(processHashes) > 0 {
+		processHash := processHashes[0]
+		processHashes = processHashes[1:]
+
+		orphans := append([]*orphanBlock(nil), bm.prevOrphans[*processHash]...)
+		for _, orphan := range orphans {
+			orphanHash := orphan.block.Hash()
+			bm.removeOrphanBlock(orphan)
+
+			bm.mtx.Unlock()
+			isMainChain, _, err := bm.chain.ProcessBlock(orphan.block, flags)
+			bm.mtx.Lock()
+			if err != nil {
+				log.Warnf("Failed to accept orphan block %v once its "+
+					"parent connected: %v", orphanHash, err)
+				continue
+			}
+
+			if isMainChain {
+				bm.notify(orphan.block)
+			}
+			processHashes = append(processHashes, orphanHash)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/classzz/classzz/chaincfg/chainhash"
+	"github.com/classzz/classzz/wire"
+	"github.com/classzz/czzutil"
+)
+
+func newTestOrphan(prev chainhash.Hash, nonce uint32) *czzutil.Block {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+		PrevBlock: prev,
+		Nonce:     nonce,
+	})
+	return czzutil.NewBlock(msgBlock)
+}
+
+// TestOldestOrphanSurvivesRemoval pins the invariant that oldestOrphan
+// always names the genuinely oldest orphan still in the pool, even after
+// the previously-tracked oldest orphan is removed for a reason other than
+// maxOrphanBlocks eviction -- the normal case, where its parent connects
+// and processOrphans removes it via removeOrphanBlock.  Before this fix,
+// that left oldestOrphan nil until the next insertion, at which point the
+// newest orphan -- not the actual oldest survivor -- was wrongly treated
+// as oldest, breaking the maxOrphanBlocks eviction invariant.
+func TestOldestOrphanSurvivesRemoval(t *testing.T) {
+	bm := New(nil, nil)
+
+	var genesis chainhash.Hash
+	first := newTestOrphan(genesis, 1)
+	second := newTestOrphan(genesis, 2)
+
+	bm.addOrphanBlock(first)
+	bm.addOrphanBlock(second)
+
+	// Force a deterministic ordering instead of relying on the
+	// resolution of consecutive time.Now() calls.
+	bm.orphans[*first.Hash()].expiration = time.Unix(1, 0)
+	bm.orphans[*second.Hash()].expiration = time.Unix(2, 0)
+	bm.oldestOrphan = bm.orphans[*first.Hash()]
+
+	if !bm.oldestOrphan.block.Hash().IsEqual(first.Hash()) {
+		t.Fatal("expected first block to be oldest orphan before removal")
+	}
+
+	bm.removeOrphanBlock(bm.oldestOrphan)
+
+	if bm.oldestOrphan == nil {
+		t.Fatal("expected oldestOrphan to be recomputed from the surviving orphan, not left nil")
+	}
+	if !bm.oldestOrphan.block.Hash().IsEqual(second.Hash()) {
+		t.Fatal("expected second block to become oldest orphan after first was removed")
+	}
+}
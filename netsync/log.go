@@ -0,0 +1,26 @@
+// Copyright (c) 2013-2017 The btcsuite developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import "github.com/btcsuite/btclog"
+
+// log is the package-level logger used throughout the netsync package.  It
+// defaults to disabled; callers that want log output should call
+// UseLogger.
+var log btclog.Logger
+
+func init() {
+	DisableLog()
+}
+
+// DisableLog disables all library log output.
+func DisableLog() {
+	log = btclog.Disabled
+}
+
+// UseLogger sets the logger used by this package.
+func UseLogger(logger btclog.Logger) {
+	log = logger
+}